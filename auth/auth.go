@@ -0,0 +1,115 @@
+// Package auth gates uploads behind per-user credentials, enforces byte and
+// file-count quotas, and mints bearer tokens for the /login endpoint.
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// User is an account allowed to upload files, with its quota. The zero
+// value for QuotaBytes or MaxFiles means "unlimited".
+type User struct {
+	Username string
+	Password string
+
+	QuotaBytes int64
+	MaxFiles   int
+
+	mu        sync.Mutex
+	usedBytes int64
+	fileCount int
+}
+
+// Reserve atomically charges size bytes and one file against the user's
+// quota, refusing if doing so would exceed it.
+func (u *User) Reserve(size int64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.QuotaBytes > 0 && u.usedBytes+size > u.QuotaBytes {
+		return false
+	}
+	if u.MaxFiles > 0 && u.fileCount+1 > u.MaxFiles {
+		return false
+	}
+	u.usedBytes += size
+	u.fileCount++
+	return true
+}
+
+// Release gives back size bytes and one file against the user's quota,
+// called once a file is deleted.
+func (u *User) Release(size int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.usedBytes -= size
+	u.fileCount--
+}
+
+// Store holds the configured users, keyed by username.
+type Store struct {
+	secret []byte
+	users  map[string]*User
+}
+
+// NewStore builds a Store from users, signing tokens with secret.
+func NewStore(secret []byte, users []*User) *Store {
+	s := &Store{secret: secret, users: make(map[string]*User, len(users))}
+	for _, u := range users {
+		s.users[u.Username] = u
+	}
+	return s
+}
+
+// User looks up a configured user by name.
+func (s *Store) User(username string) (*User, bool) {
+	u, ok := s.users[username]
+	return u, ok
+}
+
+// Authenticate resolves the user behind an incoming request's Authorization
+// header, accepting either HTTP Basic credentials or a bearer token minted
+// by Login.
+func (s *Store) Authenticate(r *http.Request) (*User, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, fmt.Errorf("auth: missing Authorization header")
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		u, ok := s.users[username]
+		if !ok || subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) != 1 {
+			return nil, fmt.Errorf("auth: invalid credentials")
+		}
+		return u, nil
+	}
+
+	const bearerPrefix = "Bearer "
+	if strings.HasPrefix(header, bearerPrefix) {
+		username, err := verify(s.secret, strings.TrimPrefix(header, bearerPrefix))
+		if err != nil {
+			return nil, err
+		}
+		u, ok := s.users[username]
+		if !ok {
+			return nil, fmt.Errorf("auth: unknown user %q", username)
+		}
+		return u, nil
+	}
+
+	return nil, fmt.Errorf("auth: unsupported Authorization scheme")
+}
+
+// Login verifies username/password and returns a signed bearer token.
+func (s *Store) Login(username, password string) (string, error) {
+	u, ok := s.users[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) != 1 {
+		return "", fmt.Errorf("auth: invalid credentials")
+	}
+	return sign(s.secret, username, tokenTTL)
+}
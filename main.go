@@ -3,115 +3,469 @@ package main
 import (
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/KeiranScript/priv/auth"
+	"github.com/KeiranScript/priv/storage"
 )
 
 const (
-	baseDir  = "./files"
 	BASE_URL = "https://i.kuuichi.xyz"
+
+	// janitorInterval is how often expired uploads are swept.
+	janitorInterval = 5 * time.Minute
 )
 
-// RandomString generates a random alphanumeric string of a specified length.
-func RandomString(length int) (string, error) {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	bytes := make([]byte, length)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
+// store is the configured Uploader backend, selected at startup by
+// loadStorageConfig.
+var store storage.Uploader
+
+// index tracks metadata (original filename, MIME type, size, upload time,
+// refcount, owner, expiry) for every distinct file content hash, independent
+// of which storage driver holds the bytes.
+var index *storage.Index
+
+// users is the configured set of accounts allowed to upload, or nil if
+// AUTH_USERS is unset, in which case uploads are unauthenticated.
+var users *auth.Store
+
+// loadStorageConfig builds a storage.Config from the environment.
+// STORAGE_SOURCE defaults to a local ./files directory; see storage.Config
+// for the accepted URL schemes.
+func loadStorageConfig() storage.Config {
+	source := os.Getenv("STORAGE_SOURCE")
+	if source == "" {
+		source = "fs://./files"
+	}
+	return storage.Config{
+		Source:    source,
+		AccessKey: os.Getenv("STORAGE_ACCESS_KEY"),
+		SecretKey: os.Getenv("STORAGE_SECRET_KEY"),
+	}
+}
+
+// loadIndexPath returns the path to the metadata index, from INDEX_PATH or
+// a default alongside the local files directory.
+func loadIndexPath() string {
+	if p := os.Getenv("INDEX_PATH"); p != "" {
+		return p
+	}
+	return "./files/index.db"
+}
+
+// loadAuthSecret returns the HMAC key used to sign bearer tokens, from
+// AUTH_SECRET, or a freshly generated one if unset (tokens won't survive a
+// restart in that case).
+func loadAuthSecret() ([]byte, error) {
+	if s := os.Getenv("AUTH_SECRET"); s != "" {
+		return []byte(s), nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generate auth secret: %w", err)
+	}
+	return secret, nil
+}
+
+// loadUsers parses AUTH_USERS, a comma-separated list of
+// "username:password:quotaBytes:maxFiles" entries (quotaBytes/maxFiles of 0
+// mean unlimited). Returns nil if AUTH_USERS is unset.
+func loadUsers() ([]*auth.User, error) {
+	raw := os.Getenv("AUTH_USERS")
+	if raw == "" {
+		return nil, nil
 	}
-	for i := range bytes {
-		bytes[i] = charset[int(bytes[i])%len(charset)]
+
+	var result []*auth.User
+	for _, entry := range strings.Split(raw, ",") {
+		fields := strings.Split(entry, ":")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid AUTH_USERS entry %q: want username:password:quotaBytes:maxFiles", entry)
+		}
+		quota, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota in AUTH_USERS entry %q: %w", entry, err)
+		}
+		maxFiles, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid max files in AUTH_USERS entry %q: %w", entry, err)
+		}
+		result = append(result, &auth.User{
+			Username:   fields[0],
+			Password:   fields[1],
+			QuotaBytes: quota,
+			MaxFiles:   maxFiles,
+		})
+	}
+	return result, nil
+}
+
+// runJanitor periodically deletes expired uploads and gives back their
+// owner's quota, until stop is closed.
+func runJanitor(stop <-chan struct{}) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sweepExpired()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sweepExpired releases and removes every reference whose TTL has elapsed,
+// independently of any other reference to the same content: a record's
+// blob and index entry are only removed once its last reference is gone.
+func sweepExpired() {
+	refs, err := index.ExpiredRefs(time.Now())
+	if err != nil {
+		fmt.Printf("janitor: list expired: %v\n", err)
+		return
 	}
-	return string(bytes), nil
+	for _, ref := range refs {
+		deleted, err := index.ReleaseUpload(ref.ID, ref.RefID)
+		if err != nil {
+			fmt.Printf("janitor: unindex %s: %v\n", ref.ID, err)
+			continue
+		}
+		if deleted {
+			if err := store.Delete(ref.ID); err != nil {
+				fmt.Printf("janitor: delete %s: %v\n", ref.ID, err)
+			}
+		}
+		if users != nil && ref.Owner != "" {
+			if owner, ok := users.User(ref.Owner); ok {
+				owner.Release(ref.Size)
+			}
+		}
+	}
+}
+
+// uploadResult describes one file accepted by storeUpload.
+type uploadResult struct {
+	id       string
+	basename string
+	size     int64
+	refID    string
 }
 
-// FileUploadHandler handles file uploads.
+// storeUpload sniffs, quota-checks, stores and indexes a single uploaded
+// file on behalf of owner (nil if auth is disabled), returning its id.
+func storeUpload(file multipart.File, header *multipart.FileHeader, owner *auth.User, expiresAt *time.Time) (uploadResult, error) {
+	mimeType, err := sniffContentType(file)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("reading file: %w", err)
+	}
+
+	basename := header.Filename
+	if ext := extensionForMIME(mimeType); ext != "" {
+		basename = strings.TrimSuffix(basename, filepath.Ext(basename)) + ext
+	}
+
+	if owner != nil && !owner.Reserve(header.Size) {
+		return uploadResult{}, errQuotaExceeded
+	}
+
+	id, err := store.Store(file, mimeType, basename)
+	if err != nil {
+		if owner != nil {
+			owner.Release(header.Size)
+		}
+		return uploadResult{}, fmt.Errorf("saving file: %w", err)
+	}
+
+	meta := storage.UploadMeta{
+		Basename:  basename,
+		MIME:      mimeType,
+		Size:      header.Size,
+		ExpiresAt: expiresAt,
+	}
+	if owner != nil {
+		meta.Owner = owner.Username
+	}
+	refID, err := index.RecordUpload(id, meta)
+	if err != nil {
+		if owner != nil {
+			owner.Release(header.Size)
+		}
+		// Only remove the blob if it didn't already exist: RecordUpload
+		// failing leaves the index untouched, so for a deduplicated upload
+		// other records may still reference this same id.
+		if _, found, getErr := index.Get(id); getErr == nil && !found {
+			if delErr := store.Delete(id); delErr != nil {
+				fmt.Printf("storeUpload: delete %s after failed index: %v\n", id, delErr)
+			}
+		}
+		return uploadResult{}, fmt.Errorf("indexing file: %w", err)
+	}
+
+	return uploadResult{id: id, basename: basename, size: header.Size, refID: refID}, nil
+}
+
+// errQuotaExceeded is returned by storeUpload when the owner's quota would
+// be exceeded.
+var errQuotaExceeded = fmt.Errorf("quota exceeded")
+
+// rollbackUpload undoes a successful storeUpload for res, releasing owner's
+// quota and removing res.id's reference for this upload, deleting its blob
+// too if that was the last reference. It's used to unwind earlier files in
+// a files[] batch after a later one in the same batch fails, so a partial
+// batch never leaves orphaned blobs charged against the owner's quota.
+func rollbackUpload(res uploadResult, owner *auth.User) {
+	deleted, err := index.ReleaseUpload(res.id, res.refID)
+	if err != nil {
+		fmt.Printf("rollback: unindex %s: %v\n", res.id, err)
+	} else if deleted {
+		if err := store.Delete(res.id); err != nil {
+			fmt.Printf("rollback: delete %s: %v\n", res.id, err)
+		}
+	}
+	if owner != nil {
+		owner.Release(res.size)
+	}
+}
+
+// hashFromID returns the content-hash portion of a storage id, stripping
+// its extension.
+func hashFromID(id string) string {
+	return strings.TrimSuffix(id, filepath.Ext(id))
+}
+
+// wantsPomf reports whether the client asked for the Pomf-style multi-file
+// response shape, via ?output=pomf or an Accept header naming it.
+func wantsPomf(r *http.Request) bool {
+	return r.URL.Query().Get("output") == "pomf" || strings.Contains(r.Header.Get("Accept"), "pomf")
+}
+
+// FileUploadHandler handles file uploads. It accepts either a single "file"
+// part (ShareX/legacy clients) or one or more "files[]" parts (Pomf/uguu
+// clients), switching its response shape based on wantsPomf.
 func FileUploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
 
-	file, header, err := r.FormFile("file")
-	if err != nil {
+	var owner *auth.User
+	if users != nil {
+		u, err := users.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="priv"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		owner = u
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	headers := r.MultipartForm.File["files[]"]
+	if len(headers) == 0 {
+		headers = r.MultipartForm.File["file"]
+	}
+	if len(headers) == 0 {
 		http.Error(w, "Error retrieving file", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	// Create the files directory if it doesn't exist
-	if err := os.MkdirAll(baseDir, os.ModePerm); err != nil {
-		http.Error(w, "Could not create directory", http.StatusInternalServerError)
+	var expiresAt *time.Time
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			http.Error(w, "Invalid ttl", http.StatusBadRequest)
+			return
+		}
+		t := time.Now().Add(d)
+		expiresAt = &t
+	}
+
+	results := make([]uploadResult, 0, len(headers))
+	for _, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			for _, done := range results {
+				rollbackUpload(done, owner)
+			}
+			http.Error(w, "Error retrieving file", http.StatusBadRequest)
+			return
+		}
+		res, err := storeUpload(file, header, owner, expiresAt)
+		file.Close()
+		if err != nil {
+			for _, done := range results {
+				rollbackUpload(done, owner)
+			}
+			if errors.Is(err, errQuotaExceeded) {
+				http.Error(w, "Quota exceeded", http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, "Error saving file", http.StatusInternalServerError)
+			}
+			return
+		}
+		results = append(results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if wantsPomf(r) {
+		files := make([]map[string]interface{}, len(results))
+		for i, res := range results {
+			files[i] = map[string]interface{}{
+				"hash": hashFromID(res.id),
+				"name": res.basename,
+				"url":  fmt.Sprintf("%s/files/%s", BASE_URL, res.id),
+				"size": res.size,
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"files":   files,
+		})
 		return
 	}
 
-	// Check the file extension
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if !strings.Contains(".jpg.jpeg.png.gif.bmp", ext) {
-		http.Error(w, "Invalid file type", http.StatusBadRequest)
+	// Legacy single-file response shape, using the first uploaded file.
+	first := results[0]
+	response := map[string]interface{}{
+		"imageUrl": fmt.Sprintf("%s/f/%s", BASE_URL, first.id),
+		"rawUrl":   fmt.Sprintf("%s/files/%s", BASE_URL, first.id),
+	}
+	if expiresAt != nil {
+		response["expiresAt"] = expiresAt.Format(time.RFC3339)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// ShareXConfigHandler serves a ShareX uploader config (.sxcu) pre-populated
+// with this server's upload endpoint, so users can drop the file into ShareX
+// and upload directly. Pass ?token=<bearer token> to bake in credentials.
+func ShareXConfigHandler(w http.ResponseWriter, r *http.Request) {
+	config := map[string]interface{}{
+		"Version":         "13.7.0",
+		"Name":            "priv",
+		"DestinationType": "ImageUploader, FileUploader",
+		"RequestMethod":   "POST",
+		"RequestURL":      BASE_URL + "/upload",
+		"Body":            "MultipartFormData",
+		"FileFormName":    "file",
+		"URL":             "$json:rawUrl$",
+		"ThumbnailURL":    "$json:imageUrl$",
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		config["Headers"] = map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="priv.sxcu"`)
+	json.NewEncoder(w).Encode(config)
+}
+
+// LoginHandler exchanges a username/password for a bearer token, for use
+// with the Authorization: Bearer header on subsequent requests.
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if users == nil {
+		http.Error(w, "Authentication is not configured", http.StatusNotFound)
 		return
 	}
 
-	// Generate a random filename
-	randomFilename, err := RandomString(6)
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+
+	token, err := users.Login(username, password)
 	if err != nil {
-		http.Error(w, "Error generating random filename", http.StatusInternalServerError)
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
-	newFilename := fmt.Sprintf("%s%s", randomFilename, ext)
-	filePath := filepath.Join(baseDir, newFilename)
 
-	// Save the uploaded file
-	out, err := os.Create(filePath)
-	if err != nil {
-		http.Error(w, "Error saving file", http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// isExpired reports whether every reference to id has elapsed its TTL, i.e.
+// the record is due for the janitor to remove it. A record with any
+// permanent (no-TTL) reference is never expired.
+func isExpired(id string) bool {
+	rec, found, err := index.Get(id)
+	return err == nil && found && rec.AllExpired(time.Now())
+}
+
+// serveRawFile streams a stored file's content straight from the configured
+// Uploader, regardless of which driver is backing it.
+func serveRawFile(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/files/")
+	if isExpired(id) {
+		http.NotFound(w, r)
 		return
 	}
-	defer out.Close()
-	if _, err := io.Copy(out, file); err != nil {
-		http.Error(w, "Error copying file", http.StatusInternalServerError)
+
+	basename, modTime, content, err := store.Load(id)
+	if err != nil {
+		http.NotFound(w, r)
 		return
 	}
+	defer content.Close()
 
-	// Construct URLs for the response
-	imageUrl := fmt.Sprintf("%s/f/%s", BASE_URL, newFilename)   // URL for the served image
-	rawUrl := fmt.Sprintf("%s/files/%s", BASE_URL, newFilename) // URL for the raw file
+	mimeType, err := sniffContentType(content)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
 
-	// Respond with the image URL and raw URL in JSON format
-	response := map[string]string{
-		"imageUrl": imageUrl,
-		"rawUrl":   rawUrl,
+	w.Header().Set("Content-Type", mimeType)
+	if !isInlineMIME(mimeType) {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", basename))
+		w.Header().Set("Content-Security-Policy", "sandbox")
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	http.ServeContent(w, r, basename, modTime, content)
 }
 
 func serveImageHTML(w http.ResponseWriter, r *http.Request) {
 	filename := strings.TrimPrefix(r.URL.Path, "/f/")
-	filePath := filepath.Join(baseDir, filename)
-
-	// Check if the file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	if isExpired(filename) {
 		http.NotFound(w, r)
 		return
 	}
 
-	// Get file info to extract necessary metadata
-	fileInfo, err := os.Stat(filePath)
+	_, _, content, err := store.Load(filename)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
+	defer content.Close()
+
+	// Determine file size by seeking to the end.
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
 
 	// Prepare metadata for the HTML response
-	fileSizeMB := float64(fileInfo.Size()) / (1024 * 1024) // Convert bytes to megabytes
-	totalUploads, err := countFiles(baseDir)               // Get total uploads
+	fileSizeMB := float64(size) / (1024 * 1024) // Convert bytes to megabytes
+	totalUploads, err := index.Count()
 	if err != nil {
 		totalUploads = 0 // Default to 0 if there's an error
 	}
@@ -119,15 +473,25 @@ func serveImageHTML(w http.ResponseWriter, r *http.Request) {
 
 	// Construct the full URL for the image
 	imageURL := fmt.Sprintf("%s/files/%s", BASE_URL, filename)
+	thumbURL := fmt.Sprintf("%s/thumb/512x512/%s", BASE_URL, filename)
 	pageURL := fmt.Sprintf("%s/f/%s", BASE_URL, filename)
 
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow all origins or specify your frontend domain
 
+	// Display the original uploaded filename when we have it on record.
+	displayName := filename
+	if rec, found, err := index.Get(filename); err == nil && found {
+		displayName = rec.Basename
+	}
+	// displayName is the client-supplied upload filename, so it must be
+	// escaped before being spliced into the HTML response below.
+	displayName = html.EscapeString(displayName)
+
 	// Improved meta tags
-	metaTitle := filename
+	metaTitle := displayName
 	metaDescription := fileDescription
-	metaImage := imageURL
+	metaImage := thumbURL
 	metaURL := pageURL
 
 	html := fmt.Sprintf(`<html>
@@ -149,21 +513,13 @@ func serveImageHTML(w http.ResponseWriter, r *http.Request) {
 				<img src="%s" alt="%s">
 			</div>
 		</body>
-	</html>`, metaTitle, metaTitle, metaDescription, metaImage, metaURL, metaTitle, metaDescription, metaImage, imageURL, filename)
+	</html>`, metaTitle, metaTitle, metaDescription, metaImage, metaURL, metaTitle, metaDescription, metaImage, imageURL, displayName)
 
 	w.Header().Set("Content-Type", "text/html")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(html))
 }
 
-func countFiles(dir string) (int, error) {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		return 0, err
-	}
-	return len(files), nil
-}
-
 // HomeHandler serves the HTML upload form.
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, `<html>
@@ -178,12 +534,50 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	var err error
+	store, err = storage.New(loadStorageConfig())
+	if err != nil {
+		fmt.Printf("Failed to initialize storage: %v\n", err)
+		os.Exit(1)
+	}
+
+	idxPath := loadIndexPath()
+	if err := os.MkdirAll(filepath.Dir(idxPath), os.ModePerm); err != nil {
+		fmt.Printf("Failed to create index directory: %v\n", err)
+		os.Exit(1)
+	}
+	index, err = storage.OpenIndex(idxPath)
+	if err != nil {
+		fmt.Printf("Failed to open index: %v\n", err)
+		os.Exit(1)
+	}
+	defer index.Close()
+
+	authUsers, err := loadUsers()
+	if err != nil {
+		fmt.Printf("Failed to load AUTH_USERS: %v\n", err)
+		os.Exit(1)
+	}
+	if authUsers != nil {
+		secret, err := loadAuthSecret()
+		if err != nil {
+			fmt.Printf("Failed to load auth secret: %v\n", err)
+			os.Exit(1)
+		}
+		users = auth.NewStore(secret, authUsers)
+	}
+
+	stopJanitor := make(chan struct{})
+	go runJanitor(stopJanitor)
+	defer close(stopJanitor)
+
 	http.HandleFunc("/", HomeHandler)
 	http.HandleFunc("/upload", FileUploadHandler)
+	http.HandleFunc("/login", LoginHandler)
+	http.HandleFunc("/sharex.sxcu", ShareXConfigHandler)
 	http.HandleFunc("/f/", serveImageHTML)
-
-	// Serve files from the ./files directory
-	http.Handle("/files/", http.StripPrefix("/files/", http.FileServer(http.Dir(baseDir))))
+	http.HandleFunc("/files/", serveRawFile)
+	http.HandleFunc("/thumb/", ThumbHandler)
 
 	fmt.Println("Server starting on :5000")
 	if err := http.ListenAndServe(":5000", nil); err != nil {
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/KeiranScript/priv/storage"
+)
+
+// sniffBytes is the number of leading bytes http.DetectContentType needs.
+const sniffBytes = 512
+
+// inlineMimePrefixes are the content-type families considered safe to render
+// directly in the browser. Everything else is served as an attachment.
+var inlineMimePrefixes = []string{"image/", "audio/", "video/"}
+
+// inlineMimeExact covers additional types allowed inline that don't fall
+// under one of inlineMimePrefixes.
+var inlineMimeExact = map[string]bool{
+	"text/plain": true,
+}
+
+// sniffContentType reads up to sniffBytes from r to detect its MIME type,
+// then seeks back to the start so the caller can read the full content.
+func sniffContentType(r io.ReadSeeker) (string, error) {
+	buf := make([]byte, sniffBytes)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// baseMIME strips any parameters (e.g. "; charset=utf-8") that
+// http.DetectContentType appends to its result.
+func baseMIME(mimeType string) string {
+	if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return strings.TrimSpace(mimeType)
+}
+
+// extensionForMIME returns the preferred file extension for mimeType,
+// falling back to the first extension mime.ExtensionsByType knows about.
+func extensionForMIME(mimeType string) string {
+	return storage.ExtensionForMIME(baseMIME(mimeType))
+}
+
+// isInlineMIME reports whether content of the given MIME type is safe to
+// serve inline (i.e. without forcing a download).
+func isInlineMIME(mimeType string) bool {
+	mimeType = baseMIME(mimeType)
+	if inlineMimeExact[mimeType] {
+		return true
+	}
+	for _, prefix := range inlineMimePrefixes {
+		if strings.HasPrefix(mimeType, prefix) {
+			return true
+		}
+	}
+	return false
+}
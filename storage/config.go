@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Config describes which Uploader to construct and where its data lives.
+//
+// Source is a URL whose scheme selects the driver:
+//
+//	fs://./files                                   local filesystem
+//	s3://bucket?endpoint=host:port&ssl=true         S3-compatible object store
+//
+// S3 credentials are read from AccessKey/SecretKey if set, otherwise from
+// the environment (AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY).
+type Config struct {
+	Source    string
+	AccessKey string
+	SecretKey string
+}
+
+// New constructs the Uploader selected by cfg.Source.
+func New(cfg Config) (Uploader, error) {
+	u, err := url.Parse(cfg.Source)
+	if err != nil {
+		return nil, fmt.Errorf("storage: parse source: %w", err)
+	}
+
+	switch u.Scheme {
+	case "fs":
+		dir := u.Opaque
+		if dir == "" {
+			dir = u.Host + u.Path
+		}
+		return NewFSDriver(dir)
+	case "s3":
+		endpoint := u.Query().Get("endpoint")
+		if endpoint == "" {
+			return nil, fmt.Errorf("storage: s3 source missing endpoint param")
+		}
+		useSSL := u.Query().Get("ssl") == "true"
+		return NewS3Driver(endpoint, u.Host, cfg.AccessKey, cfg.SecretKey, useSSL)
+	default:
+		return nil, fmt.Errorf("storage: unknown driver scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FSDriver is an Uploader backed by a directory on the local filesystem.
+type FSDriver struct {
+	dir string
+}
+
+// NewFSDriver returns a driver that stores files under dir, creating it if
+// it does not already exist.
+func NewFSDriver(dir string) (*FSDriver, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("storage: create dir: %w", err)
+	}
+	return &FSDriver{dir: dir}, nil
+}
+
+func (d *FSDriver) Store(r io.Reader, mimeType, basename string) (string, error) {
+	tmp, err := os.CreateTemp(d.dir, ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("storage: create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		return "", fmt.Errorf("storage: write file: %w", err)
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))[:hashIDLen]
+	id := hash + idExtension(mimeType)
+	dest := filepath.Join(d.dir, id)
+
+	if _, err := os.Stat(dest); err == nil {
+		return id, nil // identical content already stored
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("storage: stat existing file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("storage: close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", fmt.Errorf("storage: rename file: %w", err)
+	}
+	return id, nil
+}
+
+func (d *FSDriver) Load(id string) (string, time.Time, io.ReadSeekCloser, error) {
+	path := filepath.Join(d.dir, id)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+	return id, info.ModTime(), f, nil
+}
+
+func (d *FSDriver) Delete(id string) error {
+	return os.Remove(filepath.Join(d.dir, id))
+}
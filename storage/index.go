@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var recordsBucket = []byte("records")
+
+// Reference records one uploader's independent claim on a record's content.
+// Content-addressed dedup means the same bytes can be uploaded by several
+// owners at different times, each with their own expiry, so a record tracks
+// one Reference per upload rather than a single shared Owner/ExpiresAt.
+type Reference struct {
+	ID        string     `json:"id"`
+	Owner     string     `json:"owner,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Expired reports whether this reference's TTL, if any, has elapsed as of now.
+func (ref Reference) Expired(now time.Time) bool {
+	return ref.ExpiresAt != nil && now.After(*ref.ExpiresAt)
+}
+
+// Record holds metadata about a stored file, keyed by its storage id, plus
+// one Reference per upload of that content.
+type Record struct {
+	Basename   string      `json:"basename"`
+	MIME       string      `json:"mime"`
+	Size       int64       `json:"size"`
+	UploadedAt time.Time   `json:"uploadedAt"`
+	Refs       []Reference `json:"refs"`
+}
+
+// RefCount reports how many independent uploads currently reference this
+// record's content.
+func (r Record) RefCount() int {
+	return len(r.Refs)
+}
+
+// AllExpired reports whether every reference to this record has a TTL that
+// has elapsed as of now. A record with no references, or with any permanent
+// (no-TTL) reference, is never AllExpired.
+func (r Record) AllExpired(now time.Time) bool {
+	if len(r.Refs) == 0 {
+		return false
+	}
+	for _, ref := range r.Refs {
+		if !ref.Expired(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// UploadMeta describes a newly stored file for RecordUpload.
+type UploadMeta struct {
+	Basename  string
+	MIME      string
+	Size      int64
+	Owner     string
+	ExpiresAt *time.Time
+}
+
+// Index is a small embedded key-value store mapping a storage id to its
+// Record, so callers can answer "what is this file called", "who
+// references it", "has it expired" and "how many files are there" without
+// scanning the backing store.
+type Index struct {
+	db *bbolt.DB
+}
+
+// OpenIndex opens (creating if necessary) the bbolt index file at path.
+func OpenIndex(path string) (*Index, error) {
+	db, err := bbolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("storage: open index: %w", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(recordsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("storage: init index: %w", err)
+	}
+	return &Index{db: db}, nil
+}
+
+// Close releases the underlying database file.
+func (idx *Index) Close() error {
+	return idx.db.Close()
+}
+
+// Get returns the Record for id, and whether it was found.
+func (idx *Index) Get(id string) (Record, bool, error) {
+	var rec Record
+	var found bool
+	err := idx.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(recordsBucket).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &rec)
+	})
+	return rec, found, err
+}
+
+// newRefID returns a fresh random identifier for a Reference, unique enough
+// to pick one out of a record's Refs for release later.
+func newRefID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("storage: generate reference id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RecordUpload registers an upload of the content stored under id as a new
+// Reference, creating the Record from meta if id isn't already known. It
+// returns the id of the new Reference, which callers must pass to
+// ReleaseUpload to undo this specific upload (e.g. on rollback or expiry)
+// without disturbing any other reference to the same content.
+func (idx *Index) RecordUpload(id string, meta UploadMeta) (string, error) {
+	refID, err := newRefID()
+	if err != nil {
+		return "", err
+	}
+
+	err = idx.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(recordsBucket)
+		v := b.Get([]byte(id))
+
+		var rec Record
+		if v != nil {
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+		} else {
+			rec = Record{
+				Basename:   meta.Basename,
+				MIME:       meta.MIME,
+				Size:       meta.Size,
+				UploadedAt: time.Now(),
+			}
+		}
+		rec.Refs = append(rec.Refs, Reference{
+			ID:        refID,
+			Owner:     meta.Owner,
+			ExpiresAt: meta.ExpiresAt,
+		})
+
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), data)
+	})
+	if err != nil {
+		return "", err
+	}
+	return refID, nil
+}
+
+// ReleaseUpload removes the reference refID from id's record, leaving any
+// other reference to the same content untouched. It reports whether the
+// record had no references left and so was deleted entirely, which tells
+// the caller the backing blob can now be removed too. Releasing an id with
+// no record, or a refID it doesn't have, is a no-op.
+func (idx *Index) ReleaseUpload(id, refID string) (deleted bool, err error) {
+	err = idx.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(recordsBucket)
+		v := b.Get([]byte(id))
+		if v == nil {
+			deleted = true
+			return nil
+		}
+
+		var rec Record
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return err
+		}
+		for i, ref := range rec.Refs {
+			if ref.ID == refID {
+				rec.Refs = append(rec.Refs[:i], rec.Refs[i+1:]...)
+				break
+			}
+		}
+		if len(rec.Refs) == 0 {
+			deleted = true
+			return b.Delete([]byte(id))
+		}
+
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), data)
+	})
+	return deleted, err
+}
+
+// Delete removes the record for id entirely.
+func (idx *Index) Delete(id string) error {
+	return idx.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(recordsBucket).Delete([]byte(id))
+	})
+}
+
+// Count reports the number of distinct files tracked by the index.
+func (idx *Index) Count() (int, error) {
+	n := 0
+	err := idx.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(recordsBucket).ForEach(func(_, _ []byte) error {
+			n++
+			return nil
+		})
+	})
+	return n, err
+}
+
+// ExpiredRef identifies one reference, within the record stored under ID,
+// whose TTL has elapsed as of now.
+type ExpiredRef struct {
+	ID    string // storage id of the record this reference belongs to
+	RefID string
+	Owner string
+	Size  int64
+}
+
+// ExpiredRefs returns every reference, across all records, whose TTL has
+// elapsed as of now, so the janitor can release and remove each
+// independently without affecting other references to the same content.
+func (idx *Index) ExpiredRefs(now time.Time) ([]ExpiredRef, error) {
+	var refs []ExpiredRef
+	err := idx.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(recordsBucket).ForEach(func(k, v []byte) error {
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			for _, ref := range rec.Refs {
+				if ref.Expired(now) {
+					refs = append(refs, ExpiredRef{
+						ID:    string(k),
+						RefID: ref.ID,
+						Owner: ref.Owner,
+						Size:  rec.Size,
+					})
+				}
+			}
+			return nil
+		})
+	})
+	return refs, err
+}
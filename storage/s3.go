@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Driver is an Uploader backed by an S3-compatible object store.
+type S3Driver struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Driver returns a driver that stores objects in bucket on the given
+// endpoint. accessKey and secretKey may be empty to use the default
+// credential chain (env vars, instance profile, etc.).
+func NewS3Driver(endpoint, bucket, accessKey, secretKey string, useSSL bool) (*S3Driver, error) {
+	var creds *credentials.Credentials
+	if accessKey != "" || secretKey != "" {
+		creds = credentials.NewStaticV4(accessKey, secretKey, "")
+	} else {
+		creds = credentials.NewEnvAWS()
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("storage: init s3 client: %w", err)
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("storage: check bucket: %w", err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, fmt.Errorf("storage: create bucket: %w", err)
+		}
+	}
+
+	return &S3Driver{client: client, bucket: bucket}, nil
+}
+
+func (d *S3Driver) Store(r io.Reader, mimeType, basename string) (string, error) {
+	tmp, err := os.CreateTemp("", "priv-upload-*")
+	if err != nil {
+		return "", fmt.Errorf("storage: create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return "", fmt.Errorf("storage: hash upload: %w", err)
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))[:hashIDLen]
+	id := hash + idExtension(mimeType)
+
+	ctx := context.Background()
+	if _, err := d.client.StatObject(ctx, d.bucket, id, minio.StatObjectOptions{}); err == nil {
+		return id, nil // identical content already stored
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("storage: rewind temp file: %w", err)
+	}
+	_, err = d.client.PutObject(ctx, d.bucket, id, tmp, size, minio.PutObjectOptions{
+		ContentType: mimeType,
+	})
+	if err != nil {
+		return "", fmt.Errorf("storage: put object: %w", err)
+	}
+	return id, nil
+}
+
+func (d *S3Driver) Load(id string) (string, time.Time, io.ReadSeekCloser, error) {
+	ctx := context.Background()
+	obj, err := d.client.GetObject(ctx, d.bucket, id, minio.GetObjectOptions{})
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return "", time.Time{}, nil, err
+	}
+	return id, info.LastModified, obj, nil
+}
+
+func (d *S3Driver) Delete(id string) error {
+	return d.client.RemoveObject(context.Background(), d.bucket, id, minio.RemoveObjectOptions{})
+}
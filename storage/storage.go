@@ -0,0 +1,74 @@
+// Package storage defines a pluggable backend for storing uploaded files.
+package storage
+
+import (
+	"io"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Uploader stores, retrieves, and removes uploaded files by an
+// implementation-defined id. Implementations must be safe for concurrent use.
+type Uploader interface {
+	// Store reads r to completion and persists it under a new id, returning
+	// that id. basename is the original filename supplied by the uploader
+	// and mimeType is its detected content type; both are implementation
+	// hints and may be ignored.
+	Store(r io.Reader, mimeType, basename string) (id string, err error)
+
+	// Load returns the original basename, last-modified time, and a seekable
+	// reader for the file stored under id.
+	Load(id string) (basename string, modTime time.Time, content io.ReadSeekCloser, err error)
+
+	// Delete removes the file stored under id.
+	Delete(id string) error
+}
+
+// hashIDLen is the number of hex characters of a SHA-256 digest used to
+// name stored files, so identical uploads collapse to one blob.
+const hashIDLen = 16
+
+// primaryExtension disambiguates content types for which mime.ExtensionsByType
+// would otherwise return a less common extension first (e.g. ".jfif" for
+// image/jpeg).
+var primaryExtension = map[string]string{
+	"image/jpeg": ".jpeg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/bmp":  ".bmp",
+	"image/webp": ".webp",
+	"audio/mpeg": ".mp3",
+	"video/mp4":  ".mp4",
+	"text/plain": ".txt",
+}
+
+// ExtensionForMIME returns the preferred file extension (with leading dot)
+// for mimeType, falling back to the first extension mime.ExtensionsByType
+// knows about, or "" if none is known.
+func ExtensionForMIME(mimeType string) string {
+	if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	mimeType = strings.TrimSpace(mimeType)
+	if ext, ok := primaryExtension[mimeType]; ok {
+		return ext
+	}
+	exts, err := mime.ExtensionsByType(mimeType)
+	if err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// idExtension is like ExtensionForMIME but always returns a usable
+// extension, falling back to ".bin" for unrecognized types. Storage ids are
+// content-addressed by hash, so the extension must come solely from the
+// sniffed MIME type: deriving it from the caller-supplied filename instead
+// would let two uploads of identical bytes land under different ids.
+func idExtension(mimeType string) string {
+	if ext := ExtensionForMIME(mimeType); ext != "" {
+		return ext
+	}
+	return ".bin"
+}
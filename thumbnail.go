@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// allowedThumbSizes restricts {w},{h} on /thumb/{w}x{h}/{filename} to a
+// small set of sizes, so a client can't force us to decode and resize to
+// arbitrary (and arbitrarily expensive) dimensions.
+var allowedThumbSizes = map[int]bool{128: true, 256: true, 512: true, 1024: true}
+
+// loadThumbDir returns the on-disk cache directory for generated
+// thumbnails, from THUMB_DIR or a default alongside the local files
+// directory.
+func loadThumbDir() string {
+	if d := os.Getenv("THUMB_DIR"); d != "" {
+		return d
+	}
+	return "./files/.thumbs"
+}
+
+// parseThumbDims parses a "{w}x{h}" path segment, validating both
+// dimensions against allowedThumbSizes.
+func parseThumbDims(s string) (width, height int, ok bool) {
+	wStr, hStr, ok := strings.Cut(s, "x")
+	if !ok {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(wStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	height, err = strconv.Atoi(hStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !allowedThumbSizes[width] || !allowedThumbSizes[height] {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// ThumbHandler serves a resized JPEG of a stored image, generating and
+// disk-caching it on first request.
+func ThumbHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/thumb/")
+	dims, filename, ok := strings.Cut(rest, "/")
+	if !ok || filename == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	width, height, ok := parseThumbDims(dims)
+	if !ok {
+		http.Error(w, "Invalid thumbnail size", http.StatusBadRequest)
+		return
+	}
+
+	if isExpired(filename) {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, modTime, content, err := store.Load(filename)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer content.Close()
+
+	thumbPath := filepath.Join(loadThumbDir(), fmt.Sprintf("%s_%dx%d.jpg", hashFromID(filename), width, height))
+	if cached, err := os.Stat(thumbPath); err == nil && cached.ModTime().After(modTime) {
+		http.ServeFile(w, r, thumbPath)
+		return
+	}
+
+	img, _, err := image.Decode(content)
+	if err != nil {
+		http.Error(w, "Unsupported image type", http.StatusUnprocessableEntity)
+		return
+	}
+	thumb := resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
+
+	if err := os.MkdirAll(filepath.Dir(thumbPath), os.ModePerm); err != nil {
+		http.Error(w, "Error creating thumbnail cache", http.StatusInternalServerError)
+		return
+	}
+	out, err := os.Create(thumbPath)
+	if err != nil {
+		http.Error(w, "Error writing thumbnail", http.StatusInternalServerError)
+		return
+	}
+	if err := jpeg.Encode(out, thumb, &jpeg.Options{Quality: 85}); err != nil {
+		out.Close()
+		http.Error(w, "Error encoding thumbnail", http.StatusInternalServerError)
+		return
+	}
+	if err := out.Close(); err != nil {
+		http.Error(w, "Error writing thumbnail", http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeFile(w, r, thumbPath)
+}